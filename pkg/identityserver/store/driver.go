@@ -0,0 +1,79 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.thethings.network/lorawan-stack/pkg/errors"
+)
+
+// Factory opens a UserStore backend for the given DSN.
+//
+// The DSN format is backend-specific; e.g. the SQL backend expects a
+// database/sql compatible connection string, while the Redis backend
+// expects a "redis://" URL.
+type Factory func(dsn string) (UserStore, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Factory)
+)
+
+// Register makes a UserStore driver available under name.
+//
+// Register follows the same convention as database/sql: backends call
+// Register from an init func, and operators select the backend by name in
+// configuration. Register panics if called twice with the same name, or
+// if factory is nil.
+func Register(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if factory == nil {
+		panic("store: Register factory is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic(fmt.Sprintf("store: Register called twice for driver %q", name))
+	}
+	drivers[name] = factory
+}
+
+// Drivers returns the sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens a UserStore using the driver registered under name, passing it
+// the given dsn. Open returns an error if name is not a registered driver.
+func Open(name, dsn string) (UserStore, error) {
+	driversMu.RLock()
+	factory, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("store: unknown driver %q (forgotten import?)", name)
+	}
+	return factory(dsn)
+}
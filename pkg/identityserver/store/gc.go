@@ -0,0 +1,59 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// TokenGC periodically reclaims expired validation tokens from a UserStore.
+//
+// Backends that already expire tokens natively (e.g. the Redis store's
+// per-key TTL) only need TokenGC as a backstop for tokens that slip past
+// that mechanism; backends with no native expiry (e.g. a plain SQL table)
+// rely on it entirely.
+type TokenGC struct {
+	store    UserStore
+	interval time.Duration
+	onError  func(error)
+}
+
+// NewTokenGC returns a TokenGC that calls store.DeleteExpiredTokens every
+// interval. onError, if non-nil, is called with the error of any failed
+// sweep; a nil onError discards sweep errors.
+func NewTokenGC(store UserStore, interval time.Duration, onError func(error)) *TokenGC {
+	return &TokenGC{store: store, interval: interval, onError: onError}
+}
+
+// Run sweeps expired tokens every g.interval until ctx is done.
+//
+// Run blocks; callers typically run it in its own goroutine, e.g.
+// `go tokenGC.Run(ctx)`.
+func (g *TokenGC) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.store.DeleteExpiredTokens(); err != nil && g.onError != nil {
+				g.onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/TheThingsNetwork/ttn/pkg/ttnpb"
+)
+
+// WithPasswordHashing wraps next so that every Create and Update call has
+// its ttnpb.User.Password replaced by hasher.Hash(password) before reaching
+// next, so that no backend ever persists a plaintext password.
+//
+// WithPasswordHashing should sit closer to the backend than WithValidation,
+// so that validation rules (e.g. a minimum password length) see the
+// plaintext password rather than its hash.
+func WithPasswordHashing(next UserStore, hasher PasswordHasher) UserStore {
+	return &hashingStore{UserStore: next, hasher: hasher}
+}
+
+type hashingStore struct {
+	UserStore
+	hasher PasswordHasher
+}
+
+// Create implements UserStore.
+func (s *hashingStore) Create(u User) error {
+	if err := s.hashPassword(u); err != nil {
+		return err
+	}
+	return s.UserStore.Create(u)
+}
+
+// Update implements UserStore.
+func (s *hashingStore) Update(ids ttnpb.UserIdentifiers, u User) error {
+	if err := s.hashPassword(u); err != nil {
+		return err
+	}
+	return s.UserStore.Update(ids, u)
+}
+
+// hashPassword replaces u's plaintext Password with its encoded hash, in
+// place, leaving an empty password (an Update that doesn't change it)
+// untouched.
+func (s *hashingStore) hashPassword(u User) error {
+	user := u.GetUser()
+	if user.Password == "" {
+		return nil
+	}
+	hash, err := s.hasher.Hash(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return nil
+}
+
+var _ UserStore = (*hashingStore)(nil)
@@ -0,0 +1,195 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"time"
+
+	"go.thethings.network/lorawan-stack/pkg/metrics"
+
+	"github.com/TheThingsNetwork/ttn/pkg/ttnpb"
+)
+
+// Metric names reported by WithMetrics, labeled by "method" (and, for
+// metricRequestErrors, "class" from metrics.ErrorClass). A backend wrapped with
+// WithMetrics gets these for free, regardless of which driver it is.
+//
+// Through a sink from metrics.NewPrometheusSink("identityserver_store"), as
+// the Redis driver uses, go-metrics prefixes each name with that service
+// name and exports request latency as a summary in milliseconds, with
+// "quantile" labels. A "slow store" alert on its p99 looks like:
+//
+//   - alert: IdentityServerStoreSlowGetByID
+//     expr: identityserver_store_identityserver_store_request_latency{method="GetByID",quantile="0.99"} > 500
+//     for: 10m
+//     labels:
+//     severity: warning
+//     annotations:
+//     summary: "identity server UserStore.GetByID p99 latency is above 500ms"
+const (
+	metricRequests       = "identityserver.store.requests"
+	metricRequestErrors  = "identityserver.store.request_errors"
+	metricRequestLatency = "identityserver.store.request_latency"
+)
+
+// WithMetrics wraps next so that every UserStore method call increments a
+// request counter, increments an error counter (by metrics.ErrorClass) on
+// failure, and reports its latency to sink, all labeled by "method". This
+// lets any UserStore backend - the Redis one as well as the SQL one -
+// inherit the same instrumentation without touching its own code.
+func WithMetrics(next UserStore, sink metrics.Sink) UserStore {
+	return &metricsStore{UserStore: next, sink: sink}
+}
+
+type metricsStore struct {
+	UserStore
+	sink metrics.Sink
+}
+
+// instrument runs fn, reporting its outcome and latency under method.
+func (s *metricsStore) instrument(method string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	s.sink.IncCounter(metricRequests, "method", method)
+	if err != nil {
+		s.sink.IncCounter(metricRequestErrors, "method", method, "class", metrics.ErrorClass(err))
+	}
+	s.sink.MeasureSince(metricRequestLatency, start, "method", method)
+	return err
+}
+
+// Create implements UserStore.
+func (s *metricsStore) Create(u User) error {
+	return s.instrument("Create", func() error { return s.UserStore.Create(u) })
+}
+
+// GetByID implements UserStore.
+func (s *metricsStore) GetByID(ids ttnpb.UserIdentifiers, specializer UserSpecializer) (User, error) {
+	var user User
+	err := s.instrument("GetByID", func() (err error) {
+		user, err = s.UserStore.GetByID(ids, specializer)
+		return err
+	})
+	return user, err
+}
+
+// List implements UserStore.
+func (s *metricsStore) List(specializer UserSpecializer) ([]User, error) {
+	var users []User
+	err := s.instrument("List", func() (err error) {
+		users, err = s.UserStore.List(specializer)
+		return err
+	})
+	return users, err
+}
+
+// Update implements UserStore.
+func (s *metricsStore) Update(ids ttnpb.UserIdentifiers, u User) error {
+	return s.instrument("Update", func() error { return s.UserStore.Update(ids, u) })
+}
+
+// Delete implements UserStore.
+func (s *metricsStore) Delete(ids ttnpb.UserIdentifiers) error {
+	return s.instrument("Delete", func() error { return s.UserStore.Delete(ids) })
+}
+
+// SaveValidationToken implements UserStore.
+func (s *metricsStore) SaveValidationToken(ids ttnpb.UserIdentifiers, purpose TokenPurpose, token ValidationToken) error {
+	return s.instrument("SaveValidationToken", func() error {
+		return s.UserStore.SaveValidationToken(ids, purpose, token)
+	})
+}
+
+// GetValidationToken implements UserStore.
+func (s *metricsStore) GetValidationToken(token string) (ttnpb.UserIdentifiers, TokenPurpose, *ValidationToken, error) {
+	var ids ttnpb.UserIdentifiers
+	var purpose TokenPurpose
+	var vt *ValidationToken
+	err := s.instrument("GetValidationToken", func() (err error) {
+		ids, purpose, vt, err = s.UserStore.GetValidationToken(token)
+		return err
+	})
+	return ids, purpose, vt, err
+}
+
+// DeleteValidationToken implements UserStore.
+func (s *metricsStore) DeleteValidationToken(token string) error {
+	return s.instrument("DeleteValidationToken", func() error { return s.UserStore.DeleteValidationToken(token) })
+}
+
+// DeleteExpiredTokens implements UserStore.
+func (s *metricsStore) DeleteExpiredTokens() error {
+	return s.instrument("DeleteExpiredTokens", func() error { return s.UserStore.DeleteExpiredTokens() })
+}
+
+// SaveAPIKey implements UserStore.
+func (s *metricsStore) SaveAPIKey(ids ttnpb.UserIdentifiers, key ttnpb.APIKey) error {
+	return s.instrument("SaveAPIKey", func() error { return s.UserStore.SaveAPIKey(ids, key) })
+}
+
+// GetAPIKey implements UserStore.
+func (s *metricsStore) GetAPIKey(key string) (ttnpb.UserIdentifiers, ttnpb.APIKey, error) {
+	var ids ttnpb.UserIdentifiers
+	var apiKey ttnpb.APIKey
+	err := s.instrument("GetAPIKey", func() (err error) {
+		ids, apiKey, err = s.UserStore.GetAPIKey(key)
+		return err
+	})
+	return ids, apiKey, err
+}
+
+// GetAPIKeyByName implements UserStore.
+func (s *metricsStore) GetAPIKeyByName(ids ttnpb.UserIdentifiers, name string) (ttnpb.APIKey, error) {
+	var apiKey ttnpb.APIKey
+	err := s.instrument("GetAPIKeyByName", func() (err error) {
+		apiKey, err = s.UserStore.GetAPIKeyByName(ids, name)
+		return err
+	})
+	return apiKey, err
+}
+
+// UpdateAPIKeyRights implements UserStore.
+func (s *metricsStore) UpdateAPIKeyRights(ids ttnpb.UserIdentifiers, key string, rights []ttnpb.Right) error {
+	return s.instrument("UpdateAPIKeyRights", func() error {
+		return s.UserStore.UpdateAPIKeyRights(ids, key, rights)
+	})
+}
+
+// ListAPIKeys implements UserStore.
+func (s *metricsStore) ListAPIKeys(ids ttnpb.UserIdentifiers) ([]ttnpb.APIKey, error) {
+	var keys []ttnpb.APIKey
+	err := s.instrument("ListAPIKeys", func() (err error) {
+		keys, err = s.UserStore.ListAPIKeys(ids)
+		return err
+	})
+	return keys, err
+}
+
+// DeleteAPIKey implements UserStore.
+func (s *metricsStore) DeleteAPIKey(ids ttnpb.UserIdentifiers, key string) error {
+	return s.instrument("DeleteAPIKey", func() error { return s.UserStore.DeleteAPIKey(ids, key) })
+}
+
+// LoadAttributes implements UserStore.
+func (s *metricsStore) LoadAttributes(ids ttnpb.UserIdentifiers, u User) error {
+	return s.instrument("LoadAttributes", func() error { return s.UserStore.LoadAttributes(ids, u) })
+}
+
+// StoreAttributes implements UserStore.
+func (s *metricsStore) StoreAttributes(ids ttnpb.UserIdentifiers, u User) error {
+	return s.instrument("StoreAttributes", func() error { return s.UserStore.StoreAttributes(ids, u) })
+}
+
+var _ UserStore = (*metricsStore)(nil)
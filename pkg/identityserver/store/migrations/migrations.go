@@ -0,0 +1,116 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migrations runs ordered, idempotent schema migrations against a
+// store.UserStore SQL backend.
+//
+// CockroachDB does not support ON DELETE CASCADE (see the TODO on
+// store.UserStore.Delete), so a SQL backend's Delete must perform the
+// cascade itself rather than relying on a database-enforced one; that
+// cascade belongs in the SQL backend, not in this package.
+package migrations
+
+import (
+	"database/sql"
+
+	"go.thethings.network/lorawan-stack/pkg/errors"
+)
+
+// Migration is a single, ordered schema change.
+//
+// ID must be unique and monotonically increasing across the lifetime of a
+// deployment; migrations are applied in ascending ID order and never reordered.
+type Migration struct {
+	ID   int
+	Name string
+
+	// Apply performs the migration against tx. Apply must be safe to run
+	// inside a transaction and must not depend on migrations that have not
+	// yet been registered.
+	Apply func(tx *sql.Tx) error
+}
+
+// Registry is an ordered set of migrations, tracked in the
+// "schema_migrations" table of the target database.
+type Registry struct {
+	migrations []Migration
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register appends m to the registry. Register panics if m.ID is not
+// greater than the ID of the last registered migration.
+func (r *Registry) Register(m Migration) {
+	if n := len(r.migrations); n > 0 && m.ID <= r.migrations[n-1].ID {
+		panic("migrations: migration IDs must be registered in increasing order")
+	}
+	r.migrations = append(r.migrations, m)
+}
+
+// Migrate applies every migration in r that has not yet been recorded in the
+// "schema_migrations" table, in order, each inside its own transaction.
+func (r *Registry) Migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		id INT PRIMARY KEY,
+		name STRING NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return errors.Errorf("migrations: could not create schema_migrations table: %s", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.Query(`SELECT id FROM schema_migrations`)
+	if err != nil {
+		return errors.Errorf("migrations: could not read schema_migrations table: %s", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, m := range r.migrations {
+		if applied[m.ID] {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Errorf("migrations: could not begin transaction for migration %d (%s): %s", m.ID, m.Name, err)
+		}
+		if err := m.Apply(tx); err != nil {
+			tx.Rollback()
+			return errors.Errorf("migrations: migration %d (%s) failed: %s", m.ID, m.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (id, name) VALUES ($1, $2)`, m.ID, m.Name); err != nil {
+			tx.Rollback()
+			return errors.Errorf("migrations: could not record migration %d (%s): %s", m.ID, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Errorf("migrations: could not commit migration %d (%s): %s", m.ID, m.Name, err)
+		}
+	}
+
+	return nil
+}
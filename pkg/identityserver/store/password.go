@@ -0,0 +1,206 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"go.thethings.network/lorawan-stack/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHasher turns a plaintext password into an encoded hash that is
+// safe to persist, and later checks a plaintext password against that hash.
+//
+// The encoded hash is self-describing (it carries the algorithm and its cost
+// parameters as a "$name$params$salt$key" string), so a UserStore backend
+// can verify passwords hashed under an older PasswordHasher configuration
+// without needing to know it up front.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password.
+	Hash(password string) (string, error)
+
+	// Verify reports whether password matches encoded.
+	Verify(encoded, password string) (bool, error)
+}
+
+// Argon2idParams are the cost parameters of an argon2id PasswordHasher.
+type Argon2idParams struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+
+	// Memory is the amount of memory used, in KiB.
+	Memory uint32
+
+	// Threads is the number of parallel threads used.
+	Threads uint8
+
+	// KeyLen is the length, in bytes, of the derived key.
+	KeyLen uint32
+
+	// SaltLen is the length, in bytes, of the random salt.
+	SaltLen uint32
+}
+
+// DefaultArgon2idParams returns the cost parameters recommended by the
+// golang.org/x/crypto/argon2 documentation for interactive logins.
+func DefaultArgon2idParams() Argon2idParams {
+	return Argon2idParams{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// NewArgon2idHasher returns a PasswordHasher that hashes passwords with
+// argon2id, using params as its cost parameters.
+func NewArgon2idHasher(params Argon2idParams) PasswordHasher {
+	return argon2idHasher{params: params}
+}
+
+// Hash implements PasswordHasher.
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Errorf("store: could not generate salt: %s", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLen)
+	return fmt.Sprintf("$argon2id$m=%d,t=%d,p=%d$%s$%s",
+		h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify implements PasswordHasher.
+func (h argon2idHasher) Verify(encoded, password string) (bool, error) {
+	var memory, time uint32
+	var threads uint8
+	salt, key, params, err := splitHash(encoded, "argon2id")
+	if err != nil {
+		return false, err
+	}
+	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, errors.Errorf("store: malformed argon2id hash: %s", err)
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// ScryptParams are the cost parameters of a scrypt PasswordHasher.
+type ScryptParams struct {
+	// N is the CPU/memory cost parameter; it must be a power of two.
+	N int
+
+	// R is the block size parameter.
+	R int
+
+	// P is the parallelization parameter.
+	P int
+
+	// KeyLen is the length, in bytes, of the derived key.
+	KeyLen int
+
+	// SaltLen is the length, in bytes, of the random salt.
+	SaltLen int
+}
+
+// DefaultScryptParams returns conservative, general-purpose scrypt cost
+// parameters (N=2^15, r=8, p=1), in line with the parameters recommended by
+// the golang.org/x/crypto/scrypt documentation for interactive logins.
+func DefaultScryptParams() ScryptParams {
+	return ScryptParams{
+		N:       1 << 15,
+		R:       8,
+		P:       1,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+type scryptHasher struct {
+	params ScryptParams
+}
+
+// NewScryptHasher returns a PasswordHasher that hashes passwords with
+// scrypt, using params as its cost parameters.
+func NewScryptHasher(params ScryptParams) PasswordHasher {
+	return scryptHasher{params: params}
+}
+
+// Hash implements PasswordHasher.
+func (h scryptHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Errorf("store: could not generate salt: %s", err)
+	}
+	key, err := scrypt.Key([]byte(password), salt, h.params.N, h.params.R, h.params.P, h.params.KeyLen)
+	if err != nil {
+		return "", errors.Errorf("store: could not hash password: %s", err)
+	}
+	return fmt.Sprintf("$scrypt$n=%d,r=%d,p=%d$%s$%s",
+		h.params.N, h.params.R, h.params.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify implements PasswordHasher.
+func (h scryptHasher) Verify(encoded, password string) (bool, error) {
+	var n, r, p int
+	salt, key, params, err := splitHash(encoded, "scrypt")
+	if err != nil {
+		return false, err
+	}
+	if _, err := fmt.Sscanf(params, "n=%d,r=%d,p=%d", &n, &r, &p); err != nil {
+		return false, errors.Errorf("store: malformed scrypt hash: %s", err)
+	}
+
+	computed, err := scrypt.Key([]byte(password), salt, n, r, p, len(key))
+	if err != nil {
+		return false, errors.Errorf("store: could not hash password: %s", err)
+	}
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// splitHash parses a "$name$params$salt$key" encoded hash produced by Hash,
+// checking that it was produced under the given algorithm name.
+func splitHash(encoded, name string) (salt, key []byte, params string, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != name {
+		return nil, nil, "", errors.Errorf("store: not a %s hash", name)
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, nil, "", errors.Errorf("store: malformed %s salt: %s", name, err)
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, "", errors.Errorf("store: malformed %s hash: %s", name, err)
+	}
+	return salt, key, parts[2], nil
+}
@@ -0,0 +1,413 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redis implements a store.UserStore backed by Redis.
+//
+// Users, API keys and validation tokens are persisted as hash entries under
+// deterministic key names, using the marshaling package's byte-map encoding.
+// Validation tokens are additionally given a Redis expiry matching their
+// ValidationToken.ExpiresIn, so that expired tokens are reclaimed by Redis
+// itself instead of relying solely on store.TokenGC.
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.thethings.network/lorawan-stack/pkg/errors"
+	"go.thethings.network/lorawan-stack/pkg/identityserver/store"
+	"go.thethings.network/lorawan-stack/pkg/marshaling"
+	"go.thethings.network/lorawan-stack/pkg/metrics"
+	"go.thethings.network/lorawan-stack/pkg/validation"
+
+	"github.com/TheThingsNetwork/ttn/pkg/ttnpb"
+)
+
+func init() {
+	store.Register("redis", Open)
+}
+
+const (
+	userKeyPrefix            = "us:user:"
+	apiKeyKeyPrefix          = "us:apikey:"
+	userAPIKeysKeyPrefix     = "us:apikeys-by-user:"
+	validationTokenKeyPrefix = "us:token:"
+)
+
+// UserStore is a store.UserStore implementation backed by Redis.
+type UserStore struct {
+	client *redis.Client
+}
+
+// Open connects to the Redis server identified by dsn (a "redis://" URL, as
+// understood by github.com/go-redis/redis) and returns a UserStore.
+func Open(dsn string) (store.UserStore, error) {
+	opt, err := redis.ParseURL(dsn)
+	if err != nil {
+		return nil, errors.Errorf("redis: invalid dsn: %s", err)
+	}
+	client := redis.NewClient(opt)
+	if err := client.Ping().Err(); err != nil {
+		return nil, errors.Errorf("redis: could not connect: %s", err)
+	}
+	sink, err := metrics.NewPrometheusSink("identityserver_store")
+	if err != nil {
+		return nil, errors.Errorf("redis: could not set up metrics: %s", err)
+	}
+	s := store.WithPasswordHashing(&UserStore{client: client}, store.NewArgon2idHasher(store.DefaultArgon2idParams()))
+	s = store.WithValidation(s, validation.New())
+	return store.WithMetrics(s, sink), nil
+}
+
+func userKey(ids ttnpb.UserIdentifiers) string {
+	return userKeyPrefix + ids.UserID
+}
+
+func apiKeyKey(key string) string {
+	return apiKeyKeyPrefix + key
+}
+
+func userAPIKeysKey(ids ttnpb.UserIdentifiers) string {
+	return userAPIKeysKeyPrefix + ids.UserID
+}
+
+func validationTokenKey(token string) string {
+	return validationTokenKeyPrefix + token
+}
+
+// Create implements store.UserStore.
+func (s *UserStore) Create(u store.User) error {
+	user := u.GetUser()
+	key := userKey(user.UserIdentifiers)
+	exists, err := s.client.Exists(key).Result()
+	if err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+	if exists == 1 {
+		return errors.Errorf("redis: user %q already exists", user.UserIdentifiers.UserID)
+	}
+	return s.put(key, user)
+}
+
+// Update implements store.UserStore.
+func (s *UserStore) Update(ids ttnpb.UserIdentifiers, u store.User) error {
+	return s.put(userKey(ids), u.GetUser())
+}
+
+func (s *UserStore) put(key string, v interface{}) error {
+	bm, err := marshaling.MarshalByteMap(v)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{}, len(bm))
+	for k, v := range bm {
+		fields[k] = v
+	}
+	return s.client.HMSet(key, fields).Err()
+}
+
+// GetByID implements store.UserStore.
+func (s *UserStore) GetByID(ids ttnpb.UserIdentifiers, specializer store.UserSpecializer) (store.User, error) {
+	key := userKey(ids)
+	bm, err := s.client.HGetAll(key).Result()
+	if err != nil {
+		return nil, errors.Errorf("redis: %s", err)
+	}
+	if len(bm) == 0 {
+		return nil, errors.Errorf("redis: user %q not found", ids.UserID)
+	}
+
+	var user ttnpb.User
+	if err := unmarshalStrings(bm, &user); err != nil {
+		return nil, err
+	}
+	return specializer(user), nil
+}
+
+// List implements store.UserStore.
+func (s *UserStore) List(specializer store.UserSpecializer) ([]store.User, error) {
+	var users []store.User
+	iter := s.client.Scan(0, userKeyPrefix+"*", 0).Iterator()
+	for iter.Next() {
+		bm, err := s.client.HGetAll(iter.Val()).Result()
+		if err != nil {
+			return nil, errors.Errorf("redis: %s", err)
+		}
+		var user ttnpb.User
+		if err := unmarshalStrings(bm, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, specializer(user))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Errorf("redis: %s", err)
+	}
+	return users, nil
+}
+
+// Delete implements store.UserStore.
+//
+// CockroachDB has no ON DELETE CASCADE (see the TODO on store.UserStore.Delete),
+// and Redis has no relational cascades either, so Delete removes the user's
+// dependent API keys and validation tokens explicitly in a single pipeline.
+func (s *UserStore) Delete(ids ttnpb.UserIdentifiers) error {
+	keys, err := s.client.SMembers(userAPIKeysKey(ids)).Result()
+	if err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	for _, key := range keys {
+		pipe.Del(apiKeyKey(key))
+	}
+	iter := s.client.Scan(0, validationTokenKeyPrefix+"*", 0).Iterator()
+	for iter.Next() {
+		owner, err := s.client.HGet(iter.Val(), "UserID").Result()
+		if err != nil && err != redis.Nil {
+			return errors.Errorf("redis: %s", err)
+		}
+		if owner == ids.UserID {
+			pipe.Del(iter.Val())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+	pipe.Del(userAPIKeysKey(ids))
+	pipe.Del(userKey(ids))
+	_, err = pipe.Exec()
+	if err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+	return nil
+}
+
+// SaveValidationToken implements store.UserStore.
+func (s *UserStore) SaveValidationToken(ids ttnpb.UserIdentifiers, purpose store.TokenPurpose, token store.ValidationToken) error {
+	key := validationTokenKey(token.ValidationToken)
+	bm, err := marshaling.MarshalByteMap(token)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{}, len(bm)+2)
+	for k, v := range bm {
+		fields[k] = v
+	}
+	fields["UserID"] = ids.UserID
+	fields["Purpose"] = int(purpose)
+
+	pipe := s.client.TxPipeline()
+	pipe.HMSet(key, fields)
+	if token.ExpiresIn > 0 {
+		pipe.Expire(key, time.Duration(token.ExpiresIn)*time.Second)
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+	return nil
+}
+
+// GetValidationToken implements store.UserStore.
+func (s *UserStore) GetValidationToken(token string) (ttnpb.UserIdentifiers, store.TokenPurpose, *store.ValidationToken, error) {
+	key := validationTokenKey(token)
+	bm, err := s.client.HGetAll(key).Result()
+	if err != nil {
+		return ttnpb.UserIdentifiers{}, 0, nil, errors.Errorf("redis: %s", err)
+	}
+	if len(bm) == 0 {
+		return ttnpb.UserIdentifiers{}, 0, nil, errors.Errorf("redis: validation token not found")
+	}
+
+	userID := bm["UserID"]
+	delete(bm, "UserID")
+	purpose, err := strconv.Atoi(bm["Purpose"])
+	if err != nil {
+		return ttnpb.UserIdentifiers{}, 0, nil, errors.Errorf("redis: malformed token purpose: %s", err)
+	}
+	delete(bm, "Purpose")
+
+	var vt store.ValidationToken
+	if err := unmarshalStrings(bm, &vt); err != nil {
+		return ttnpb.UserIdentifiers{}, 0, nil, err
+	}
+	return ttnpb.UserIdentifiers{UserID: userID}, store.TokenPurpose(purpose), &vt, nil
+}
+
+// DeleteExpiredTokens implements store.UserStore.
+//
+// Redis already expires each token key via the TTL set in SaveValidationToken,
+// so this is a backstop for tokens saved with no ExpiresIn, or left over from
+// before TTLs were set; see the package doc comment.
+func (s *UserStore) DeleteExpiredTokens() error {
+	iter := s.client.Scan(0, validationTokenKeyPrefix+"*", 0).Iterator()
+	for iter.Next() {
+		key := iter.Val()
+		bm, err := s.client.HGetAll(key).Result()
+		if err != nil {
+			return errors.Errorf("redis: %s", err)
+		}
+		delete(bm, "UserID")
+		delete(bm, "Purpose")
+		var vt store.ValidationToken
+		if err := unmarshalStrings(bm, &vt); err != nil {
+			return err
+		}
+		if vt.IsExpired() {
+			if err := s.client.Del(key).Err(); err != nil {
+				return errors.Errorf("redis: %s", err)
+			}
+		}
+	}
+	return iter.Err()
+}
+
+// DeleteValidationToken implements store.UserStore.
+func (s *UserStore) DeleteValidationToken(token string) error {
+	return s.client.Del(validationTokenKey(token)).Err()
+}
+
+// SaveAPIKey implements store.UserStore.
+func (s *UserStore) SaveAPIKey(ids ttnpb.UserIdentifiers, key ttnpb.APIKey) error {
+	pipe := s.client.TxPipeline()
+	if err := s.putPipe(pipe, apiKeyKey(key.Key), key); err != nil {
+		return err
+	}
+	// GetAPIKey has no owner->key index to search, only the reverse
+	// userAPIKeysKey set, so the owning UserID is stashed alongside the
+	// key's own fields - the same trick SaveValidationToken uses.
+	pipe.HSet(apiKeyKey(key.Key), "UserID", ids.UserID)
+	pipe.SAdd(userAPIKeysKey(ids), key.Key)
+	_, err := pipe.Exec()
+	if err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+	return nil
+}
+
+func (s *UserStore) putPipe(pipe redis.Pipeliner, key string, v interface{}) error {
+	bm, err := marshaling.MarshalByteMap(v)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{}, len(bm))
+	for k, v := range bm {
+		fields[k] = v
+	}
+	pipe.HMSet(key, fields)
+	return nil
+}
+
+// GetAPIKey implements store.UserStore.
+func (s *UserStore) GetAPIKey(key string) (ttnpb.UserIdentifiers, ttnpb.APIKey, error) {
+	bm, err := s.client.HGetAll(apiKeyKey(key)).Result()
+	if err != nil {
+		return ttnpb.UserIdentifiers{}, ttnpb.APIKey{}, errors.Errorf("redis: %s", err)
+	}
+	if len(bm) == 0 {
+		return ttnpb.UserIdentifiers{}, ttnpb.APIKey{}, errors.Errorf("redis: api key not found")
+	}
+
+	userID := bm["UserID"]
+	delete(bm, "UserID")
+
+	var apiKey ttnpb.APIKey
+	if err := unmarshalStrings(bm, &apiKey); err != nil {
+		return ttnpb.UserIdentifiers{}, ttnpb.APIKey{}, err
+	}
+	return ttnpb.UserIdentifiers{UserID: userID}, apiKey, nil
+}
+
+// GetAPIKeyByName implements store.UserStore.
+func (s *UserStore) GetAPIKeyByName(ids ttnpb.UserIdentifiers, name string) (ttnpb.APIKey, error) {
+	keys, err := s.client.SMembers(userAPIKeysKey(ids)).Result()
+	if err != nil {
+		return ttnpb.APIKey{}, errors.Errorf("redis: %s", err)
+	}
+	for _, key := range keys {
+		_, apiKey, err := s.GetAPIKey(key)
+		if err != nil {
+			return ttnpb.APIKey{}, err
+		}
+		if apiKey.Name == name {
+			return apiKey, nil
+		}
+	}
+	return ttnpb.APIKey{}, errors.Errorf("redis: api key %q not found", name)
+}
+
+// UpdateAPIKeyRights implements store.UserStore.
+func (s *UserStore) UpdateAPIKeyRights(ids ttnpb.UserIdentifiers, key string, rights []ttnpb.Right) error {
+	_, apiKey, err := s.GetAPIKey(key)
+	if err != nil {
+		return err
+	}
+	apiKey.Rights = rights
+	return s.put(apiKeyKey(key), apiKey)
+}
+
+// ListAPIKeys implements store.UserStore.
+func (s *UserStore) ListAPIKeys(ids ttnpb.UserIdentifiers) ([]ttnpb.APIKey, error) {
+	keys, err := s.client.SMembers(userAPIKeysKey(ids)).Result()
+	if err != nil {
+		return nil, errors.Errorf("redis: %s", err)
+	}
+	apiKeys := make([]ttnpb.APIKey, 0, len(keys))
+	for _, key := range keys {
+		_, apiKey, err := s.GetAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, nil
+}
+
+// DeleteAPIKey implements store.UserStore.
+func (s *UserStore) DeleteAPIKey(ids ttnpb.UserIdentifiers, key string) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(apiKeyKey(key))
+	pipe.SRem(userAPIKeysKey(ids), key)
+	_, err := pipe.Exec()
+	if err != nil {
+		return errors.Errorf("redis: %s", err)
+	}
+	return nil
+}
+
+// LoadAttributes implements store.UserStore.
+//
+// Redis has no equivalent to the SQL store's separate attributes table:
+// attributes are stored inline on the user hash, so LoadAttributes is a no-op.
+func (s *UserStore) LoadAttributes(ids ttnpb.UserIdentifiers, u store.User) error {
+	return nil
+}
+
+// StoreAttributes implements store.UserStore.
+//
+// See LoadAttributes: attributes already round-trip through Create/Update.
+func (s *UserStore) StoreAttributes(ids ttnpb.UserIdentifiers, u store.User) error {
+	return nil
+}
+
+func unmarshalStrings(bm map[string]string, v interface{}) error {
+	bbm := make(map[string][]byte, len(bm))
+	for k, v := range bm {
+		bbm[k] = []byte(v)
+	}
+	return marshaling.UnmarshalByteMap(bbm, v)
+}
+
+var _ store.UserStore = (*UserStore)(nil)
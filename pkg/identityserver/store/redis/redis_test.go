@@ -0,0 +1,52 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"go.thethings.network/lorawan-stack/pkg/identityserver/store"
+	"go.thethings.network/lorawan-stack/pkg/identityserver/store/storetest"
+	"go.thethings.network/lorawan-stack/pkg/marshaling"
+)
+
+// TestUserStore runs the storetest conformance suite against a UserStore
+// backed by miniredis, an in-memory Redis server - this is what would have
+// caught the us:user:/us:apikeys-by-user: key prefix collision that broke
+// List on any store that had ever saved an API key.
+func TestUserStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start miniredis: %s", err)
+	}
+	defer mr.Close()
+
+	// ValidationToken.ValidationToken is tagged for encryption, so
+	// MarshalByteMap fails unless a KeyProvider is configured.
+	provider, err := marshaling.NewStaticKeyProvider("test", bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatalf("could not create key provider: %s", err)
+	}
+	marshaling.SetKeyProvider(provider)
+	defer marshaling.SetKeyProvider(nil)
+
+	storetest.TestUserStore(t, func() store.UserStore {
+		mr.FlushAll()
+		return &UserStore{client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	})
+}
@@ -22,14 +22,21 @@ import (
 
 // ValidationToken is an expirable token.
 type ValidationToken struct {
-	// ValidationToken is the token itself.
-	ValidationToken string
+	// ValidationToken is the token itself. It is marked for envelope
+	// encryption at rest (see marshaling.ShouldEncryptField): it is a bearer
+	// credential for the email validation, password reset and API key
+	// issuance flows, and marshaling.MarshalByteMap - which the Redis store
+	// uses to encode it - encrypts it under the package's configured
+	// KeyProvider before writing it. ttnpb.User.Password and ttnpb.APIKey.Key
+	// are equally sensitive but, being defined outside this snapshot, cannot
+	// be tagged here.
+	ValidationToken string `validate:"required" ttn:"encrypt"`
 
 	// CreatedAt denotes when the token was created.
 	CreatedAt time.Time
 
 	// ExpiresIn denotes the TTL of the token in seconds.
-	ExpiresIn int32
+	ExpiresIn int32 `validate:"min=1"`
 }
 
 // IsExpired checks whether the token is expired or not.
@@ -37,6 +44,39 @@ func (v ValidationToken) IsExpired() bool {
 	return v.CreatedAt.Add(time.Duration(v.ExpiresIn) * time.Second).Before(time.Now())
 }
 
+// TokenPurpose identifies the email flow a ValidationToken was issued for, so
+// that a single store primitive can back the identity server's verify, reset
+// and invite emails without them accepting one another's tokens.
+type TokenPurpose int
+
+const (
+	// TokenPurposeEmailValidation marks a token issued to confirm a user's
+	// email address.
+	TokenPurposeEmailValidation TokenPurpose = iota
+
+	// TokenPurposePasswordReset marks a token issued to let a user set a new
+	// password without knowing the old one.
+	TokenPurposePasswordReset
+
+	// TokenPurposeAPIKeyIssuance marks a token issued to confirm the creation
+	// of a new API key by email.
+	TokenPurposeAPIKeyIssuance
+)
+
+// String implements fmt.Stringer.
+func (p TokenPurpose) String() string {
+	switch p {
+	case TokenPurposeEmailValidation:
+		return "email_validation"
+	case TokenPurposePasswordReset:
+		return "password_reset"
+	case TokenPurposeAPIKeyIssuance:
+		return "api_key_issuance"
+	default:
+		return "unknown"
+	}
+}
+
 // User is the interface of all things that can be an User. This can be used to
 // build richer user types that can still be read and written to a database.
 type User interface {
@@ -65,15 +105,23 @@ type UserStore interface {
 	// Delete deletes an user.
 	Delete(ttnpb.UserIdentifiers) error
 
-	// SaveValidationToken saves the validation token.
-	SaveValidationToken(ttnpb.UserIdentifiers, ValidationToken) error
+	// SaveValidationToken saves the validation token issued for the given purpose.
+	SaveValidationToken(ttnpb.UserIdentifiers, TokenPurpose, ValidationToken) error
 
-	// GetValidationToken retrieves the validation token.
-	GetValidationToken(string) (ttnpb.UserIdentifiers, *ValidationToken, error)
+	// GetValidationToken retrieves the validation token and the purpose it was issued for.
+	GetValidationToken(string) (ttnpb.UserIdentifiers, TokenPurpose, *ValidationToken, error)
 
 	// DeleteValidationToken deletes the validation token.
 	DeleteValidationToken(string) error
 
+	// DeleteExpiredTokens deletes every validation token that has expired.
+	//
+	// Backends that already expire tokens natively (e.g. the Redis store's
+	// per-key TTL) may implement this as a cheap sweep rather than relying on
+	// it as their only reclamation path; TokenGC calls it periodically as a
+	// backend-agnostic backstop.
+	DeleteExpiredTokens() error
+
 	// SaveAPIKey stores an API Key attached to an user.
 	SaveAPIKey(ttnpb.UserIdentifiers, ttnpb.APIKey) error
 
@@ -0,0 +1,89 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"reflect"
+
+	"go.thethings.network/lorawan-stack/pkg/validation"
+
+	"github.com/TheThingsNetwork/ttn/pkg/ttnpb"
+)
+
+// ttnpb.User and ttnpb.APIKey are defined outside this tree, so they cannot
+// carry `validate:"..."` struct tags directly; register the equivalent
+// rules by field name instead, so that WithValidation actually rejects
+// malformed users and API keys instead of only being reachable through
+// store.ValidationToken's tags.
+func init() {
+	validation.RegisterExternalFieldRules(reflect.TypeOf(ttnpb.User{}),
+		validation.ExternalFieldRule{Field: "Name", Rule: "required"},
+		validation.ExternalFieldRule{Field: "Email", Rule: "required"},
+		validation.ExternalFieldRule{Field: "Email", Rule: "email"},
+		validation.ExternalFieldRule{Field: "Password", Rule: "password"},
+		validation.ExternalFieldRule{Field: "Password", Rule: "min", Arg: "8"},
+	)
+	validation.RegisterExternalFieldRules(reflect.TypeOf(ttnpb.APIKey{}),
+		validation.ExternalFieldRule{Field: "Name", Rule: "required"},
+		validation.ExternalFieldRule{Field: "Rights", Rule: "rights", Arg: "user:*"},
+	)
+}
+
+// WithValidation wraps next so that every Create, Update and SaveAPIKey call
+// is validated with v before reaching next. This lets every backend - the
+// SQL implementation as well as the Redis one - share a single validation
+// pass instead of reimplementing it.
+func WithValidation(next UserStore, v validation.Validator) UserStore {
+	return &validatingStore{UserStore: next, validator: v}
+}
+
+type validatingStore struct {
+	UserStore
+	validator validation.Validator
+}
+
+// Create implements UserStore.
+func (s *validatingStore) Create(u User) error {
+	if err := s.validator.Validate(u.GetUser()); err != nil {
+		return err
+	}
+	return s.UserStore.Create(u)
+}
+
+// Update implements UserStore.
+func (s *validatingStore) Update(ids ttnpb.UserIdentifiers, u User) error {
+	if err := s.validator.Validate(u.GetUser()); err != nil {
+		return err
+	}
+	return s.UserStore.Update(ids, u)
+}
+
+// SaveAPIKey implements UserStore.
+func (s *validatingStore) SaveAPIKey(ids ttnpb.UserIdentifiers, key ttnpb.APIKey) error {
+	if err := s.validator.Validate(key); err != nil {
+		return err
+	}
+	return s.UserStore.SaveAPIKey(ids, key)
+}
+
+// SaveValidationToken implements UserStore.
+func (s *validatingStore) SaveValidationToken(ids ttnpb.UserIdentifiers, purpose TokenPurpose, token ValidationToken) error {
+	if err := s.validator.Validate(token); err != nil {
+		return err
+	}
+	return s.UserStore.SaveValidationToken(ids, purpose, token)
+}
+
+var _ UserStore = (*validatingStore)(nil)
@@ -0,0 +1,87 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"reflect"
+	"strings"
+)
+
+// CBOREncoding and CapnProtoEncoding extend the Encoding enum (ZeroEncoding
+// through GobEncoding) with two more compact, self-describing formats: CBOR
+// suits free-form data such as attribute maps, while CapnProtoEncoding is
+// for types that already have generated Cap'n Proto codecs.
+const (
+	CBOREncoding Encoding = iota + 7
+	CapnProtoEncoding
+)
+
+// capnpMessage is implemented by generated Cap'n Proto message types that
+// can decode themselves from a single, unframed segment. BytesToType uses
+// it the same way it uses proto.Message for ProtoEncoding.
+type capnpMessage interface {
+	UnmarshalCapnProto([]byte) error
+}
+
+var capnpMessageType = reflect.TypeOf((*capnpMessage)(nil)).Elem()
+
+// FieldEncodingTag is the struct tag read by the marshal side to let a
+// field opt out of the store's default Encoding, e.g.:
+//
+//	type Session struct {
+//		MACState   MACState               `ttn:"enc=proto"`
+//		Attributes map[string]interface{} `ttn:"enc=cbor"`
+//	}
+//
+// Hot, frequently-decoded fields like MACState stay on the compact,
+// wire-compatible proto codec, while free-form fields like Attributes use
+// CBOR's self-describing maps instead of requiring a fixed proto schema.
+const FieldEncodingTag = "ttn"
+
+// FieldEncoding returns the Encoding named in field's FieldEncodingTag
+// struct tag (`ttn:"enc=cbor"`), and whether one was present at all. A
+// missing tag, or one without an "enc" key, reports ok as false so the
+// caller falls back to its own default Encoding.
+func FieldEncoding(field reflect.StructField) (enc Encoding, ok bool) {
+	tag, has := field.Tag.Lookup(FieldEncodingTag)
+	if !has {
+		return 0, false
+	}
+
+	for _, part := range strings.Split(tag, ",") {
+		name, val := part, ""
+		if idx := strings.IndexByte(part, '='); idx >= 0 {
+			name, val = part[:idx], part[idx+1:]
+		}
+		if name != "enc" {
+			continue
+		}
+		switch val {
+		case "cbor":
+			return CBOREncoding, true
+		case "capnproto":
+			return CapnProtoEncoding, true
+		case "proto":
+			return ProtoEncoding, true
+		case "json":
+			return JSONEncoding, true
+		case "msgpack":
+			return MsgPackEncoding, true
+		case "gob":
+			return GobEncoding, true
+		}
+	}
+	return 0, false
+}
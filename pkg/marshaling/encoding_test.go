@@ -0,0 +1,158 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor"
+	"github.com/gogo/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/TheThingsNetwork/ttn/pkg/ttnpb"
+)
+
+// sampleUser is the representative ttnpb message used to compare encoding
+// size and decode cost: small enough to benchmark cheaply, but with enough
+// fields (nested identifiers, a string and a timestamp-like field) to be
+// representative of a typical stored User.
+func sampleUser() ttnpb.User {
+	return ttnpb.User{
+		UserIdentifiers: ttnpb.UserIdentifiers{UserID: "bench-user"},
+		Name:            "Benchmark User",
+		Email:           "bench-user@example.com",
+	}
+}
+
+func encode(tb testing.TB, enc Encoding, v interface{}) []byte {
+	var payload []byte
+	var err error
+	switch enc {
+	case JSONEncoding:
+		payload, err = json.Marshal(v)
+	case ProtoEncoding:
+		msg, ok := v.(proto.Message)
+		if !ok {
+			// Generated messages implement proto.Message on their pointer type.
+			ptr := reflect.New(reflect.TypeOf(v))
+			ptr.Elem().Set(reflect.ValueOf(v))
+			msg = ptr.Interface().(proto.Message)
+		}
+		payload, err = proto.Marshal(msg)
+	case MsgPackEncoding:
+		payload, err = msgpack.Marshal(v)
+	case CBOREncoding:
+		payload, err = cbor.Marshal(v, cbor.EncOptions{})
+	case GobEncoding:
+		var buf bytes.Buffer
+		err = gob.NewEncoder(&buf).EncodeValue(reflect.ValueOf(v))
+		payload = buf.Bytes()
+	default:
+		tb.Fatalf("encode: unsupported encoding %d", enc)
+	}
+	if err != nil {
+		tb.Fatalf("encode with %d: %s", enc, err)
+	}
+	return append([]byte{byte(DefaultVersion), byte(enc)}, payload...)
+}
+
+// TestBytesToType_UnknownVersion ensures BytesToType keeps rejecting bytes
+// whose version byte it does not recognize, rather than silently
+// misinterpreting the rest of the payload under a newer encoding.
+func TestBytesToType_UnknownVersion(t *testing.T) {
+	b := []byte{0xff, byte(JSONEncoding)}
+	if _, err := BytesToType(b, reflect.TypeOf(ttnpb.User{})); err == nil {
+		t.Fatal("expected an error for an unknown version byte, got nil")
+	}
+}
+
+func TestBytesToType_CBOR(t *testing.T) {
+	user := sampleUser()
+	b := encode(t, CBOREncoding, user)
+
+	got, err := BytesToType(b, reflect.TypeOf(ttnpb.User{}))
+	if err != nil {
+		t.Fatalf("BytesToType: %s", err)
+	}
+	if got.(ttnpb.User).UserID != user.UserID {
+		t.Fatalf("got UserID %q, want %q", got.(ttnpb.User).UserID, user.UserID)
+	}
+}
+
+func benchmarkDecode(b *testing.B, enc Encoding) {
+	user := sampleUser()
+	payload := encode(b, enc, user)
+	b.ReportMetric(float64(len(payload)), "bytes/msg")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := BytesToType(payload, reflect.TypeOf(ttnpb.User{})); err != nil {
+			b.Fatalf("BytesToType: %s", err)
+		}
+	}
+}
+
+// taggedField is a struct used to exercise FieldEncoding and its use by
+// UnmarshalMap: Data opts into CBOR, Plain has no tag and falls back to
+// whatever encoding its bytes actually carry.
+type taggedField struct {
+	Data  string `ttn:"enc=cbor"`
+	Plain string
+}
+
+func TestFieldEncoding(t *testing.T) {
+	typ := reflect.TypeOf(taggedField{})
+
+	data, _ := typ.FieldByName("Data")
+	enc, ok := FieldEncoding(data)
+	if !ok || enc != CBOREncoding {
+		t.Fatalf("FieldEncoding(Data) = (%d, %v), want (%d, true)", enc, ok, CBOREncoding)
+	}
+
+	plain, _ := typ.FieldByName("Plain")
+	if _, ok := FieldEncoding(plain); ok {
+		t.Fatal("FieldEncoding(Plain) reported a hint for an untagged field")
+	}
+}
+
+// TestUnmarshalMapFieldEncoding checks that UnmarshalMap accepts a field
+// whose stored bytes match its "enc=" hint, and rejects one that doesn't -
+// catching the case where a field was re-encoded under a different Encoding
+// than the one its tag promises.
+func TestUnmarshalMapFieldEncoding(t *testing.T) {
+	var s taggedField
+	matching := map[string]interface{}{"Data": encode(t, CBOREncoding, "hello")}
+	if err := UnmarshalMap(matching, &s); err != nil {
+		t.Fatalf("UnmarshalMap with matching encoding: %s", err)
+	}
+	if s.Data != "hello" {
+		t.Fatalf("got Data %q, want %q", s.Data, "hello")
+	}
+
+	mismatched := map[string]interface{}{"Data": encode(t, JSONEncoding, "hello")}
+	if err := UnmarshalMap(mismatched, &taggedField{}); err == nil {
+		t.Fatal("expected an error decoding a field whose bytes don't match its enc tag")
+	}
+}
+
+func BenchmarkBytesToType_JSON(b *testing.B)    { benchmarkDecode(b, JSONEncoding) }
+func BenchmarkBytesToType_Proto(b *testing.B)   { benchmarkDecode(b, ProtoEncoding) }
+func BenchmarkBytesToType_MsgPack(b *testing.B) { benchmarkDecode(b, MsgPackEncoding) }
+func BenchmarkBytesToType_Gob(b *testing.B)     { benchmarkDecode(b, GobEncoding) }
+func BenchmarkBytesToType_CBOR(b *testing.B)    { benchmarkDecode(b, CBOREncoding) }
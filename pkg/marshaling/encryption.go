@@ -0,0 +1,208 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+
+	"go.thethings.network/lorawan-stack/pkg/errors"
+)
+
+// EncryptedEncoding extends the Encoding enum with an envelope-encryption
+// layer: its payload is a KeyProvider-wrapped data-encryption-key (DEK)
+// followed by the field's real, normally-encoded bytes (version byte,
+// Encoding byte and all) encrypted under that DEK with AES-256-GCM.
+//
+// Decoding recurses: BytesToType decrypts the envelope and feeds the
+// recovered plaintext back into itself, so the field's real Encoding -
+// proto, CBOR, whatever - is handled exactly as if it had never been
+// encrypted.
+const EncryptedEncoding Encoding = CapnProtoEncoding + 1
+
+// dekSize is the size, in bytes, of the per-field AES-256 data-encryption
+// key that EncryptPayload generates for every call.
+const dekSize = 32
+
+// KeyProvider wraps and unwraps per-field data-encryption-keys (DEKs) under
+// a key-encryption-key (KEK), so the DEK - not the KEK - ever appears in the
+// encrypted payload.
+//
+// Implementations range from a single static local key (NewStaticKeyProvider)
+// or a rotatable local keyset (NewFileKeysetProvider) to an external KMS, such
+// as HashiCorp Vault's Transit secrets engine or AWS KMS, that performs the
+// wrap/unwrap itself and never reveals the KEK at all.
+type KeyProvider interface {
+	// WrapKey encrypts dek under the provider's current KEK, returning the
+	// wrapped key and the KEK version it was wrapped under.
+	WrapKey(ctx context.Context, dek []byte) (wrapped []byte, version string, err error)
+
+	// UnwrapKey decrypts wrapped, which must have been produced by WrapKey
+	// under the named KEK version. Implementations must keep honoring past
+	// versions after the current one rotates, so that data encrypted before
+	// a rotation keeps decoding.
+	UnwrapKey(ctx context.Context, version string, wrapped []byte) (dek []byte, err error)
+}
+
+// keyProvider is consulted by BytesToType to decrypt EncryptedEncoding
+// payloads. It is nil - decryption fails clearly - until an application
+// opts in via SetKeyProvider.
+var keyProvider KeyProvider
+
+// SetKeyProvider configures the KeyProvider that BytesToType uses to
+// decrypt EncryptedEncoding payloads, and that the marshal side (see
+// MarshalMap) uses to encrypt ShouldEncryptField fields.
+func SetKeyProvider(p KeyProvider) {
+	keyProvider = p
+}
+
+// FieldEncryptionTag is the tag value (within the FieldEncodingTag struct
+// tag) that marks a field for envelope encryption, e.g. `ttn:"encrypt"`, or
+// combined with an encoding hint as `ttn:"enc=cbor,encrypt"`.
+const FieldEncryptionTag = "encrypt"
+
+// ShouldEncryptField reports whether field is tagged for envelope
+// encryption. MarshalMap and MarshalByteMap consult it to decide whether a
+// field's encoded bytes must be routed through EncryptPayload before being
+// written, e.g. for ValidationToken.ValidationToken; ttnpb.User.Password and
+// ttnpb.APIKey.Key are equally sensitive but, being defined outside this
+// tree, cannot carry this tag directly.
+func ShouldEncryptField(field reflect.StructField) bool {
+	tag, has := field.Tag.Lookup(FieldEncodingTag)
+	if !has {
+		return false
+	}
+	for _, part := range strings.Split(tag, ",") {
+		if part == FieldEncryptionTag {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptPayload encrypts payload - the normal, already-encoded bytes of a
+// field (including its own version and Encoding header) - under a fresh DEK
+// wrapped by provider, and returns the bytes MarshalMap should write in
+// payload's place: a version byte, an EncryptedEncoding byte, and the
+// envelope described by decryptEnvelope.
+func EncryptPayload(ctx context.Context, provider KeyProvider, payload []byte) ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, errors.Errorf("marshaling: could not generate data encryption key: %s", err)
+	}
+
+	wrapped, version, err := provider.WrapKey(ctx, dek)
+	if err != nil {
+		return nil, errors.Errorf("marshaling: could not wrap data encryption key: %s", err)
+	}
+
+	ciphertext, nonce, err := aesGCMSeal(dek, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(version) > 255 {
+		return nil, errors.Errorf("marshaling: KEK version %q is too long to encode", version)
+	}
+	if len(wrapped) > 65535 {
+		return nil, errors.Errorf("marshaling: wrapped data encryption key is too long to encode")
+	}
+
+	envelope := make([]byte, 0, 1+len(version)+2+len(wrapped)+len(nonce)+len(ciphertext))
+	envelope = append(envelope, byte(len(version)))
+	envelope = append(envelope, version...)
+	envelope = appendUint16(envelope, uint16(len(wrapped)))
+	envelope = append(envelope, wrapped...)
+	envelope = append(envelope, nonce...)
+	envelope = append(envelope, ciphertext...)
+
+	return append([]byte{byte(DefaultVersion), byte(EncryptedEncoding)}, envelope...), nil
+}
+
+// decryptEnvelope reverses EncryptPayload's envelope (everything after the
+// outer version and EncryptedEncoding bytes), returning the field's
+// original, plainly-encoded payload.
+func decryptEnvelope(ctx context.Context, provider KeyProvider, envelope []byte) ([]byte, error) {
+	if len(envelope) < 1 {
+		return nil, errors.Errorf("marshaling: truncated encryption envelope")
+	}
+	versionLen := int(envelope[0])
+	envelope = envelope[1:]
+	if len(envelope) < versionLen+2 {
+		return nil, errors.Errorf("marshaling: truncated encryption envelope")
+	}
+	version := string(envelope[:versionLen])
+	envelope = envelope[versionLen:]
+
+	wrappedLen := int(binary.BigEndian.Uint16(envelope[:2]))
+	envelope = envelope[2:]
+	if len(envelope) < wrappedLen {
+		return nil, errors.Errorf("marshaling: truncated encryption envelope")
+	}
+	wrapped := envelope[:wrappedLen]
+	envelope = envelope[wrappedLen:]
+
+	dek, err := provider.UnwrapKey(ctx, version, wrapped)
+	if err != nil {
+		return nil, errors.Errorf("marshaling: could not unwrap data encryption key (KEK version %q): %s", version, err)
+	}
+
+	return aesGCMOpen(dek, envelope)
+}
+
+func aesGCMSeal(key, plaintext []byte) (ciphertext, nonce []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, errors.Errorf("marshaling: could not set up cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, errors.Errorf("marshaling: could not set up cipher: %s", err)
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, errors.Errorf("marshaling: could not generate nonce: %s", err)
+	}
+	return gcm.Seal(nil, nonce, plaintext, nil), nonce, nil
+}
+
+func aesGCMOpen(key, nonceAndCiphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Errorf("marshaling: could not set up cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Errorf("marshaling: could not set up cipher: %s", err)
+	}
+	if len(nonceAndCiphertext) < gcm.NonceSize() {
+		return nil, errors.Errorf("marshaling: truncated ciphertext")
+	}
+	nonce := nonceAndCiphertext[:gcm.NonceSize()]
+	ciphertext := nonceAndCiphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
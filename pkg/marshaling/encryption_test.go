@@ -0,0 +1,84 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestEncryptPayloadRoundTrip(t *testing.T) {
+	provider, err := NewStaticKeyProvider("v1", bytes.Repeat([]byte{0x42}, kekSize))
+	if err != nil {
+		t.Fatalf("NewStaticKeyProvider: %s", err)
+	}
+
+	payload := []byte{byte(DefaultVersion), byte(JSONEncoding), '"', 'h', 'i', '"'}
+
+	envelope, err := EncryptPayload(context.Background(), provider, payload)
+	if err != nil {
+		t.Fatalf("EncryptPayload: %s", err)
+	}
+	if Encoding(envelope[1]) != EncryptedEncoding {
+		t.Fatalf("expected envelope to be tagged EncryptedEncoding, got %d", envelope[1])
+	}
+
+	plain, err := decryptEnvelope(context.Background(), provider, envelope[2:])
+	if err != nil {
+		t.Fatalf("decryptEnvelope: %s", err)
+	}
+	if !bytes.Equal(plain, payload) {
+		t.Fatalf("decrypted payload %v does not match original %v", plain, payload)
+	}
+}
+
+func TestFileKeysetProviderRotation(t *testing.T) {
+	keys := map[string][]byte{
+		"v1": bytes.Repeat([]byte{0x11}, kekSize),
+		"v2": bytes.Repeat([]byte{0x22}, kekSize),
+	}
+
+	oldProvider := &fileKeysetProvider{current: "v1", keys: keys}
+	newProvider := &fileKeysetProvider{current: "v2", keys: keys}
+
+	payload := []byte("under v1")
+	wrapped, version, err := oldProvider.WrapKey(context.Background(), []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("WrapKey: %s", err)
+	}
+	if version != "v1" {
+		t.Fatalf("expected version v1, got %q", version)
+	}
+
+	// A provider that has since rotated to v2 must still unwrap data DEKs
+	// wrapped under the retired v1 key.
+	dek, err := newProvider.UnwrapKey(context.Background(), version, wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey after rotation: %s", err)
+	}
+
+	ciphertext, nonce, err := aesGCMSeal(dek, payload)
+	if err != nil {
+		t.Fatalf("aesGCMSeal: %s", err)
+	}
+	plain, err := aesGCMOpen(dek, append(nonce, ciphertext...))
+	if err != nil {
+		t.Fatalf("aesGCMOpen: %s", err)
+	}
+	if !bytes.Equal(plain, payload) {
+		t.Fatalf("decrypted payload %q does not match original %q", plain, payload)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+
+	"go.thethings.network/lorawan-stack/pkg/errors"
+)
+
+const kekSize = 32
+
+// staticKeyProvider is a KeyProvider with a single, unrotatable KEK. It is
+// meant for development and single-node setups; production deployments
+// that need rotation should use NewFileKeysetProvider or a KMS-backed
+// provider such as VaultTransitKeyProvider.
+type staticKeyProvider struct {
+	version string
+	kek     []byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider that wraps every DEK under the
+// single 32-byte (AES-256) kek, tagged with version.
+func NewStaticKeyProvider(version string, kek []byte) (KeyProvider, error) {
+	if len(kek) != kekSize {
+		return nil, errors.Errorf("marshaling: static KEK must be %d bytes (AES-256), got %d", kekSize, len(kek))
+	}
+	return &staticKeyProvider{version: version, kek: kek}, nil
+}
+
+// WrapKey implements KeyProvider.
+func (p *staticKeyProvider) WrapKey(ctx context.Context, dek []byte) ([]byte, string, error) {
+	ciphertext, nonce, err := aesGCMSeal(p.kek, dek)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(nonce, ciphertext...), p.version, nil
+}
+
+// UnwrapKey implements KeyProvider.
+func (p *staticKeyProvider) UnwrapKey(ctx context.Context, version string, wrapped []byte) ([]byte, error) {
+	if version != p.version {
+		return nil, errors.Errorf("marshaling: unknown KEK version %q", version)
+	}
+	return aesGCMOpen(p.kek, wrapped)
+}
+
+// keysetFile is the on-disk format read by NewFileKeysetProvider: Current
+// names the version that WrapKey uses, while Keys lists every version -
+// including retired ones - that UnwrapKey must keep honoring.
+//
+//	{
+//		"current": "2018-09",
+//		"keys": {
+//			"2018-06": "<base64 32-byte key>",
+//			"2018-09": "<base64 32-byte key>"
+//		}
+//	}
+type keysetFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// fileKeysetProvider is a KeyProvider backed by a keysetFile on disk. To
+// rotate, add a new entry to Keys and point Current at it: WrapKey then uses
+// the new version, while UnwrapKey keeps decoding rows still encrypted under
+// retired versions. Existing rows are not re-encrypted under the new
+// version; this package has no helper that walks a UserStore to do so.
+type fileKeysetProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewFileKeysetProvider reads the keyset at path.
+func NewFileKeysetProvider(path string) (KeyProvider, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Errorf("marshaling: could not read keyset %q: %s", path, err)
+	}
+
+	var kf keysetFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, errors.Errorf("marshaling: could not parse keyset %q: %s", path, err)
+	}
+	if _, ok := kf.Keys[kf.Current]; !ok {
+		return nil, errors.Errorf("marshaling: keyset %q has no key for its current version %q", path, kf.Current)
+	}
+
+	keys := make(map[string][]byte, len(kf.Keys))
+	for version, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Errorf("marshaling: keyset %q: malformed key for version %q: %s", path, version, err)
+		}
+		if len(key) != kekSize {
+			return nil, errors.Errorf("marshaling: keyset %q: key for version %q must be %d bytes (AES-256), got %d", path, version, kekSize, len(key))
+		}
+		keys[version] = key
+	}
+
+	return &fileKeysetProvider{current: kf.Current, keys: keys}, nil
+}
+
+// WrapKey implements KeyProvider.
+func (p *fileKeysetProvider) WrapKey(ctx context.Context, dek []byte) ([]byte, string, error) {
+	ciphertext, nonce, err := aesGCMSeal(p.keys[p.current], dek)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(nonce, ciphertext...), p.current, nil
+}
+
+// UnwrapKey implements KeyProvider.
+func (p *fileKeysetProvider) UnwrapKey(ctx context.Context, version string, wrapped []byte) ([]byte, error) {
+	kek, ok := p.keys[version]
+	if !ok {
+		return nil, errors.Errorf("marshaling: unknown KEK version %q", version)
+	}
+	return aesGCMOpen(kek, wrapped)
+}
+
+var (
+	_ KeyProvider = (*staticKeyProvider)(nil)
+	_ KeyProvider = (*fileKeysetProvider)(nil)
+)
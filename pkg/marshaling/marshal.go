@@ -0,0 +1,295 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+
+	"github.com/fxamacker/cbor"
+	"github.com/gogo/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+	"go.thethings.network/lorawan-stack/pkg/errors"
+)
+
+// MapMarshaler is the interface implemented by an object that can marshal
+// itself into a map[string]interface{} representation.
+//
+// MarshalMap must produce a form UnmarshalMap can decode.
+type MapMarshaler interface {
+	MarshalMap() (map[string]interface{}, error)
+}
+
+// ByteMapMarshaler is the interface implemented by an object that can
+// marshal itself into a map[string][]byte representation.
+//
+// MarshalByteMap must produce a form UnmarshalByteMap can decode.
+type ByteMapMarshaler interface {
+	MarshalByteMap() (map[string][]byte, error)
+}
+
+// MarshalMap encodes v - a struct, or a MapMarshaler - into a
+// map[string]interface{}, the inverse of UnmarshalMap: a field of a basic
+// kind (string, bool, a numeric kind) with no "enc" hint and no encryption
+// tag is stored as-is, since UnmarshalMap
+// accepts those directly, while every other field is encoded into the
+// version byte, Encoding byte and payload that BytesToType reads back (see
+// TypeToBytes), since UnmarshalMap only accepts those as []byte.
+//
+// A field tagged with FieldEncodingTag's "enc" key (see FieldEncoding)
+// is encoded with the named Encoding instead of the default.
+func MarshalMap(v interface{}) (map[string]interface{}, error) {
+	if mm, ok := v.(MapMarshaler); ok {
+		return mm.MarshalMap()
+	}
+
+	rv, err := addressableStructValue(v)
+	if err != nil || !rv.IsValid() {
+		return nil, err
+	}
+
+	rt := rv.Type()
+	m := make(map[string]interface{}, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fv := rv.Field(i)
+
+		if isBasicKind(fv.Kind()) {
+			if _, hinted := FieldEncoding(field); !hinted && !ShouldEncryptField(field) {
+				m[field.Name] = fv.Interface()
+				continue
+			}
+		}
+
+		b, err := marshalField(field, fv)
+		if err != nil {
+			return nil, err
+		}
+		m[field.Name] = b
+	}
+	return m, nil
+}
+
+// MarshalByteMap encodes v - a struct, or a ByteMapMarshaler - into a
+// map[string][]byte, the inverse of UnmarshalByteMap. Unlike MarshalMap,
+// every field - including ones of a basic kind - is encoded into bytes,
+// since a map[string][]byte cannot hold a field's native Go value.
+func MarshalByteMap(v interface{}) (map[string][]byte, error) {
+	if bm, ok := v.(ByteMapMarshaler); ok {
+		return bm.MarshalByteMap()
+	}
+
+	rv, err := addressableStructValue(v)
+	if err != nil || !rv.IsValid() {
+		return nil, err
+	}
+
+	rt := rv.Type()
+	bm := make(map[string][]byte, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		b, err := marshalField(field, rv.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		bm[field.Name] = b
+	}
+	return bm, nil
+}
+
+// addressableStructValue indirects v - which may be a struct or a pointer
+// to one - into an addressable struct reflect.Value, copying it first if it
+// wasn't already addressable, so that a field of a pointer-receiver type
+// (e.g. a proto.Message generated with pointer Marshal methods) can still
+// be addressed with Value.Addr.
+func addressableStructValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, nil
+		}
+		rv = rv.Elem()
+	} else {
+		addr := reflect.New(rv.Type())
+		addr.Elem().Set(rv)
+		rv = addr.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, errors.Errorf("marshaling: %T is not a struct", v)
+	}
+	return rv, nil
+}
+
+// isBasicKind reports whether k is one of the kinds UnmarshalMap accepts as
+// a map value in its own right, rather than as encoded bytes.
+func isBasicKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	default:
+		return false
+	}
+}
+
+// marshalField encodes fv into the bytes TypeToBytes produces, using
+// field's FieldEncoding hint if present, or defaultEncoding otherwise. A
+// field tagged ShouldEncryptField is, in addition, routed through
+// EncryptPayload under the package's configured KeyProvider (see
+// SetKeyProvider) before being returned.
+func marshalField(field reflect.StructField, fv reflect.Value) ([]byte, error) {
+	enc, ok := FieldEncoding(field)
+	if !ok {
+		enc = defaultEncoding(fv)
+	}
+
+	b, err := TypeToBytes(fv.Interface(), enc)
+	if err != nil {
+		return nil, errors.Errorf("marshaling: could not encode field %q: %s", field.Name, err)
+	}
+
+	if ShouldEncryptField(field) {
+		if keyProvider == nil {
+			return nil, errors.Errorf("marshaling: field %q is tagged for encryption, but no KeyProvider is configured (see SetKeyProvider)", field.Name)
+		}
+		b, err = EncryptPayload(context.Background(), keyProvider, b)
+		if err != nil {
+			return nil, errors.Errorf("marshaling: could not encrypt field %q: %s", field.Name, err)
+		}
+	}
+	return b, nil
+}
+
+// defaultEncoding picks the Encoding TypeToBytes falls back to for a field
+// with no explicit FieldEncoding hint: ZeroEncoding for a nil nillable
+// value, ProtoEncoding for a proto.Message, BigEndianEncoding for a plain
+// scalar kind, and JSONEncoding - a safe, generic default - for everything
+// else (structs, slices, maps).
+func defaultEncoding(fv reflect.Value) Encoding {
+	if IsNillableType(fv.Type()) && fv.IsNil() {
+		return ZeroEncoding
+	}
+	if _, ok := fv.Interface().(proto.Message); ok {
+		return ProtoEncoding
+	}
+	if isBasicKind(fv.Kind()) {
+		return BigEndianEncoding
+	}
+	return JSONEncoding
+}
+
+// TypeToBytes encodes v into the version byte, Encoding byte and payload
+// format that BytesToType reads back, using enc. It is the encode-side
+// counterpart of BytesToType, used by MarshalMap and MarshalByteMap to
+// encode individual fields.
+func TypeToBytes(v interface{}, enc Encoding) ([]byte, error) {
+	header := []byte{byte(DefaultVersion), byte(enc)}
+	if enc == ZeroEncoding {
+		return header, nil
+	}
+
+	switch enc {
+	case BigEndianEncoding, LittleEndianEncoding:
+		payload, err := marshalBigEndian(reflect.ValueOf(v), enc)
+		if err != nil {
+			return nil, err
+		}
+		return append(header, payload...), nil
+
+	case JSONEncoding:
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(header, payload...), nil
+
+	case ProtoEncoding:
+		msg, ok := v.(proto.Message)
+		if !ok {
+			return nil, errors.Errorf("marshaling: %T does not implement proto.Message", v)
+		}
+		payload, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return append(header, payload...), nil
+
+	case MsgPackEncoding:
+		payload, err := msgpack.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(header, payload...), nil
+
+	case GobEncoding:
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).EncodeValue(reflect.ValueOf(v)); err != nil {
+			return nil, err
+		}
+		return append(header, buf.Bytes()...), nil
+
+	case CBOREncoding:
+		payload, err := cbor.Marshal(v, cbor.EncOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return append(header, payload...), nil
+
+	default:
+		return nil, errors.Errorf("marshaling: encoding %s has no marshal-side support", encodingName(enc))
+	}
+}
+
+// marshalBigEndian encodes rv - a value of a plain scalar kind - under bo,
+// the byte order enc names: a string is written as its raw bytes, and
+// platform-sized int/uint are widened to a fixed 64 bits, mirroring exactly
+// what BytesToType's BigEndianEncoding/LittleEndianEncoding case expects to
+// read back for those kinds; every other kind is written directly, since
+// binary.Write already supports fixed-size numeric kinds on its own.
+func marshalBigEndian(rv reflect.Value, enc Encoding) ([]byte, error) {
+	bo := binary.ByteOrder(binary.BigEndian)
+	if enc == LittleEndianEncoding {
+		bo = binary.LittleEndian
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch rv.Kind() {
+	case reflect.String:
+		_, err = buf.WriteString(rv.String())
+	case reflect.Int:
+		err = binary.Write(&buf, bo, rv.Int())
+	case reflect.Uint, reflect.Uintptr:
+		err = binary.Write(&buf, bo, rv.Uint())
+	default:
+		err = binary.Write(&buf, bo, rv.Interface())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import "testing"
+
+// TestMarshalMapRoundTrip checks that MarshalMap produces a form UnmarshalMap
+// can decode back, for both a basic-kind field (Plain) and a tagged,
+// explicitly-encoded one (Data).
+func TestMarshalMapRoundTrip(t *testing.T) {
+	in := taggedField{Data: "hello", Plain: "world"}
+
+	m, err := MarshalMap(in)
+	if err != nil {
+		t.Fatalf("MarshalMap: %s", err)
+	}
+	if m["Plain"] != "world" {
+		t.Fatalf("MarshalMap: Plain = %v, want %q", m["Plain"], "world")
+	}
+	if _, ok := m["Data"].([]byte); !ok {
+		t.Fatalf("MarshalMap: Data = %T, want []byte", m["Data"])
+	}
+
+	var out taggedField
+	if err := UnmarshalMap(m, &out); err != nil {
+		t.Fatalf("UnmarshalMap: %s", err)
+	}
+	if out != in {
+		t.Fatalf("UnmarshalMap(MarshalMap(%+v)) = %+v", in, out)
+	}
+}
+
+// TestMarshalByteMapRoundTrip checks that MarshalByteMap produces a form
+// UnmarshalByteMap can decode back, including the basic-kind field that
+// MarshalMap would otherwise leave as a native Go value.
+func TestMarshalByteMapRoundTrip(t *testing.T) {
+	in := taggedField{Data: "hello", Plain: "world"}
+
+	bm, err := MarshalByteMap(in)
+	if err != nil {
+		t.Fatalf("MarshalByteMap: %s", err)
+	}
+
+	var out taggedField
+	if err := UnmarshalByteMap(bm, &out); err != nil {
+		t.Fatalf("UnmarshalByteMap: %s", err)
+	}
+	if out != in {
+		t.Fatalf("UnmarshalByteMap(MarshalByteMap(%+v)) = %+v", in, out)
+	}
+}
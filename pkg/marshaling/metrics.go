@@ -0,0 +1,101 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marshaling
+
+import (
+	"time"
+
+	"go.thethings.network/lorawan-stack/pkg/metrics"
+)
+
+const (
+	metricDecodeRequests = "marshaling.decode_requests"
+	metricDecodeErrors   = "marshaling.decode_errors"
+	metricDecodeLatency  = "marshaling.decode_latency"
+	metricBytesDecoded   = "marshaling.bytes_decoded"
+)
+
+type noopSink struct{}
+
+func (noopSink) IncCounter(name string, labels ...string)                    {}
+func (noopSink) AddSample(name string, value float32, labels ...string)      {}
+func (noopSink) MeasureSince(name string, start time.Time, labels ...string) {}
+
+var metricsSink metrics.Sink = noopSink{}
+
+// SetMetricsSink configures where BytesToType (and, through it, UnmarshalMap
+// and UnmarshalByteMap) report decode metrics. The default is a no-op sink,
+// so importing marshaling costs nothing unless an application opts in.
+func SetMetricsSink(sink metrics.Sink) {
+	if sink == nil {
+		sink = noopSink{}
+	}
+	metricsSink = sink
+}
+
+// recordDecodeMetrics reports a single BytesToType call: a request count, an
+// error count (by metrics.ErrorClass) on failure, decode latency, and the
+// number of bytes decoded - all labeled by the Encoding found in b.
+func recordDecodeMetrics(b []byte, start time.Time, err error) {
+	enc := peekEncoding(b)
+	name := encodingName(enc)
+
+	metricsSink.IncCounter(metricDecodeRequests, "encoding", name)
+	if err != nil {
+		metricsSink.IncCounter(metricDecodeErrors, "encoding", name, "class", metrics.ErrorClass(err))
+	}
+	metricsSink.MeasureSince(metricDecodeLatency, start, "encoding", name)
+	metricsSink.AddSample(metricBytesDecoded, float32(len(b)), "encoding", name)
+}
+
+// peekEncoding reads the Encoding byte out of a BytesToType payload (the
+// second byte, after the version byte) without otherwise parsing it.
+func peekEncoding(b []byte) Encoding {
+	if len(b) < 2 {
+		return ZeroEncoding
+	}
+	return Encoding(b[1])
+}
+
+// encodingName returns the metric label for enc. It is a plain function
+// rather than an Encoding method so as not to risk colliding with a method
+// of the same name on the Encoding type declared outside this package's
+// checked-out files.
+func encodingName(enc Encoding) string {
+	switch enc {
+	case ZeroEncoding:
+		return "zero"
+	case BigEndianEncoding:
+		return "big_endian"
+	case LittleEndianEncoding:
+		return "little_endian"
+	case JSONEncoding:
+		return "json"
+	case ProtoEncoding:
+		return "proto"
+	case MsgPackEncoding:
+		return "msgpack"
+	case GobEncoding:
+		return "gob"
+	case CBOREncoding:
+		return "cbor"
+	case CapnProtoEncoding:
+		return "capnproto"
+	case EncryptedEncoding:
+		return "encrypted"
+	default:
+		return "unknown"
+	}
+}
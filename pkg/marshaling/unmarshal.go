@@ -16,13 +16,16 @@ package marshaling
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"io"
 	"reflect"
 	"strings"
+	"time"
 
+	"github.com/fxamacker/cbor"
 	"github.com/gogo/protobuf/proto"
 	"github.com/mitchellh/mapstructure"
 	"github.com/tinylib/msgp/msgp"
@@ -103,6 +106,8 @@ func UnmarshalMap(m map[string]interface{}, v interface{}, hooks ...mapstructure
 		skeys := strings.Split(mk, Separator)
 
 		fv := rv
+		var sf reflect.StructField
+		var sfOK bool
 		for _, sk := range skeys {
 			for fv.Kind() == reflect.Ptr {
 				if fv.IsNil() {
@@ -110,6 +115,7 @@ func UnmarshalMap(m map[string]interface{}, v interface{}, hooks ...mapstructure
 				}
 				fv = fv.Elem()
 			}
+			sf, sfOK = fv.Type().FieldByName(sk)
 			if fv = fv.FieldByName(sk); !fv.IsValid() {
 				return errors.Errorf("field `%s` specified, but does not exist on structs of type `%s`", sk, fv.Type())
 			}
@@ -130,7 +136,22 @@ func UnmarshalMap(m map[string]interface{}, v interface{}, hooks ...mapstructure
 
 		case reflect.Slice:
 			if rmv.Type().Elem().Kind() == reflect.Uint8 {
-				iv, err := BytesToType(rmv.Bytes(), fv.Type())
+				b := rmv.Bytes()
+				if sfOK && !ShouldEncryptField(sf) {
+					// An encrypted field's stored bytes legitimately carry
+					// EncryptedEncoding regardless of any "enc=" hint, which
+					// only describes what's inside the envelope - so the
+					// comparison below would be meaningless for it.
+					if wantEnc, ok := FieldEncoding(sf); ok {
+						if gotEnc := peekEncoding(b); gotEnc != wantEnc {
+							return ErrInvalidData.NewWithCause(nil, errors.Errorf(
+								"field `%s` is tagged for %s encoding, but its stored bytes carry %s",
+								mk, encodingName(wantEnc), encodingName(gotEnc)))
+						}
+					}
+				}
+
+				iv, err := BytesToType(b, fv.Type())
 				if err != nil {
 					return err
 				}
@@ -152,7 +173,20 @@ func UnmarshalMap(m map[string]interface{}, v interface{}, hooks ...mapstructure
 // BytesToType expects the first byte in b to represent the encoding version
 // used to encode the value and the second byte to represent the encoding
 // and attempts to decode accordingly.
-func BytesToType(b []byte, typ reflect.Type) (interface{}, error) {
+//
+// BytesToType reports its outcome to the package's metrics sink (see
+// SetMetricsSink), incrementing a request and, on failure, an error counter,
+// recording decode latency, and recording len(b) as a sample of the
+// bytes_decoded histogram (it is not added to a counter) - all labeled by
+// the Encoding read from b, so operators can see which
+// encodings dominate decode volume and which are slow.
+func BytesToType(b []byte, typ reflect.Type) (v interface{}, err error) {
+	start := time.Now()
+	defer func() { recordDecodeMetrics(b, start, err) }()
+	return decodeBytesToType(b, typ)
+}
+
+func decodeBytesToType(b []byte, typ reflect.Type) (interface{}, error) {
 	if len(b) == 0 {
 		return nil, ErrInvalidData.NewWithCause(nil, errors.Errorf("empty byte slice specified"))
 	}
@@ -290,6 +324,32 @@ func BytesToType(b []byte, typ reflect.Type) (interface{}, error) {
 		err := gob.NewDecoder(buf).DecodeValue(pv.Elem())
 		return pv.Elem().Interface(), err
 
+	case CBOREncoding:
+		err := cbor.Unmarshal(buf.Bytes(), pv.Interface())
+		return pv.Elem().Interface(), err
+
+	case CapnProtoEncoding:
+		rv := ev
+		if !ev.Type().Implements(capnpMessageType) {
+			if !pv.Type().Implements(capnpMessageType) {
+				return nil, errors.Errorf("expected %s or %s to implement %s", ev.Type(), pv.Type(), capnpMessageType)
+			}
+			rv = pv
+		}
+
+		err := rv.Interface().(capnpMessage).UnmarshalCapnProto(buf.Bytes())
+		return pv.Elem().Interface(), err
+
+	case EncryptedEncoding:
+		if keyProvider == nil {
+			return nil, errors.Errorf("marshaling: no KeyProvider configured to decrypt an EncryptedEncoding payload")
+		}
+		plain, err := decryptEnvelope(context.Background(), keyProvider, buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		return decodeBytesToType(plain, typ)
+
 	default:
 		return nil, ErrInvalidData.NewWithCause(nil, errors.Errorf("unmatched encoding"))
 	}
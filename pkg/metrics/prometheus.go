@@ -0,0 +1,72 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"time"
+
+	gometrics "github.com/armon/go-metrics"
+	gometricsprom "github.com/armon/go-metrics/prometheus"
+)
+
+// gometricsSink is a Sink backed by github.com/armon/go-metrics, exported
+// through its Prometheus sink so every counter and histogram it sees ends up
+// on the application's existing Prometheus registry.
+type gometricsSink struct {
+	client *gometrics.Metrics
+}
+
+// NewPrometheusSink returns a Sink that reports counters and histograms
+// through go-metrics' Prometheus sink, under serviceName's namespace.
+func NewPrometheusSink(serviceName string) (Sink, error) {
+	promSink, err := gometricsprom.NewPrometheusSink()
+	if err != nil {
+		return nil, err
+	}
+	cfg := gometrics.DefaultConfig(serviceName)
+	cfg.EnableHostname = false
+	client, err := gometrics.New(cfg, promSink)
+	if err != nil {
+		return nil, err
+	}
+	return &gometricsSink{client: client}, nil
+}
+
+// IncCounter implements Sink.
+func (s *gometricsSink) IncCounter(name string, labels ...string) {
+	s.client.IncrCounterWithLabels([]string{name}, 1, toLabels(labels))
+}
+
+// AddSample implements Sink.
+func (s *gometricsSink) AddSample(name string, value float32, labels ...string) {
+	s.client.AddSampleWithLabels([]string{name}, value, toLabels(labels))
+}
+
+// MeasureSince implements Sink.
+func (s *gometricsSink) MeasureSince(name string, start time.Time, labels ...string) {
+	s.client.MeasureSinceWithLabels([]string{name}, start, toLabels(labels))
+}
+
+// toLabels turns alternating key/value pairs into go-metrics Labels,
+// silently dropping a trailing unpaired key.
+func toLabels(kv []string) []gometrics.Label {
+	labels := make([]gometrics.Label, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		labels = append(labels, gometrics.Label{Name: kv[i], Value: kv[i+1]})
+	}
+	return labels
+}
+
+var _ Sink = (*gometricsSink)(nil)
@@ -0,0 +1,57 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics provides a minimal telemetry sink abstraction so that
+// instrumented packages (see store.WithMetrics and marshaling.SetMetricsSink)
+// don't need to import a specific metrics client directly.
+package metrics
+
+import "time"
+
+// Sink receives request counts, error counts and latency/size observations
+// from instrumented packages.
+//
+// Labels are passed as alternating key/value pairs, following the
+// convention of github.com/armon/go-metrics, which NewPrometheusSink wraps.
+type Sink interface {
+	// IncCounter increments the named counter by one.
+	IncCounter(name string, labels ...string)
+
+	// AddSample adds value to the named histogram.
+	AddSample(name string, value float32, labels ...string)
+
+	// MeasureSince records the elapsed time since start against the named
+	// histogram.
+	MeasureSince(name string, start time.Time, labels ...string)
+}
+
+// classifier is implemented by errors that can report their own class (e.g.
+// "not_found", "invalid_argument"), such as the errors produced by
+// go.thethings.network/lorawan-stack/pkg/errors.
+type classifier interface {
+	Class() string
+}
+
+// ErrorClass returns the class of err for use as a metric label: the class
+// reported by err itself if it implements classifier, "none" if err is nil,
+// or "unknown" otherwise.
+func ErrorClass(err error) string {
+	if err == nil {
+		return "none"
+	}
+	if c, ok := err.(classifier); ok {
+		return c.Class()
+	}
+	return "unknown"
+}
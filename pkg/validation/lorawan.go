@@ -0,0 +1,79 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"reflect"
+	"regexp"
+)
+
+// eui64Len is the length, in bytes, of a DevEUI or JoinEUI.
+const eui64Len = 8
+
+var frequencyPlanIDPattern = regexp.MustCompile(`^[A-Z0-9_]+_[0-9]+(_[0-9]+)?$`)
+
+// devEUIRule validates a `validate:"deveui"` tagged field, which must be an
+// 8-byte value (either a [8]byte-like array/slice or a types.EUI64).
+func devEUIRule(rv reflect.Value, _ string) string {
+	if desc := eui64Rule(rv); desc != "" {
+		return "DevEUI " + desc
+	}
+	return ""
+}
+
+// joinEUIRule validates a `validate:"joineui"` tagged field. JoinEUIs share
+// the DevEUI's 8-byte wire format.
+func joinEUIRule(rv reflect.Value, _ string) string {
+	if desc := eui64Rule(rv); desc != "" {
+		return "JoinEUI " + desc
+	}
+	return ""
+}
+
+func eui64Rule(rv reflect.Value) string {
+	switch rv.Kind() {
+	case reflect.Array:
+		if rv.Len() != eui64Len {
+			return "must be 8 bytes"
+		}
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return ""
+		}
+		if rv.Len() != eui64Len {
+			return "must be 8 bytes"
+		}
+	default:
+		return "must be an 8-byte EUI"
+	}
+	return ""
+}
+
+// frequencyPlanRule validates a `validate:"freqplan"` tagged field against
+// the "REGION_SUBBAND" naming convention used for frequency plan IDs
+// (e.g. "EU_863_870", "US_902_928_FSB_1").
+func frequencyPlanRule(rv reflect.Value, _ string) string {
+	if rv.Kind() != reflect.String {
+		return ""
+	}
+	s := rv.String()
+	if s == "" {
+		return ""
+	}
+	if !frequencyPlanIDPattern.MatchString(s) {
+		return "must be a valid frequency plan ID"
+	}
+	return ""
+}
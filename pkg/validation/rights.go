@@ -0,0 +1,48 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/TheThingsNetwork/ttn/pkg/ttnpb"
+)
+
+// rightsRule validates a `validate:"rights=user:*"` tagged []ttnpb.Right
+// field, rejecting rights outside of the scope named by arg (e.g. a request
+// to create an API key scoped to "user:*" must not carry "application:*"
+// rights).
+//
+// ttnpb.Right values are named "RIGHT_<SCOPE>_...", so "user:*" maps to the
+// "RIGHT_USER_" prefix.
+func rightsRule(rv reflect.Value, arg string) string {
+	if rv.Kind() != reflect.Slice || arg == "" {
+		return ""
+	}
+	scope := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(arg, "*"), ":"))
+	prefix := "RIGHT_" + scope + "_"
+
+	for i := 0; i < rv.Len(); i++ {
+		right, ok := rv.Index(i).Interface().(ttnpb.Right)
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(right.String(), prefix) {
+			return "contains a right outside of the " + arg + " scope"
+		}
+	}
+	return ""
+}
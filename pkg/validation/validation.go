@@ -0,0 +1,272 @@
+// Copyright © 2018 The Things Network Foundation, The Things Industries B.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validation performs declarative validation of structs driven by
+// `validate:"..."` struct tags, e.g.:
+//
+//	type User struct {
+//		Email    string `validate:"required,email"`
+//		Password string `validate:"required,min=8,password"`
+//	}
+//
+// Multiple comma-separated rules may be given per field; all of them are
+// checked, and every failure is collected into a single *Error so that a
+// caller (typically a store.UserStore implementation, see
+// go.thethings.network/lorawan-stack/pkg/identityserver/store) can report all
+// problems with a request at once instead of failing on the first one.
+//
+// Types defined outside this tree - ttnpb.User and ttnpb.APIKey, most
+// notably - cannot be given `validate:"..."` struct tags directly. For
+// those, use RegisterExternalFieldRules to attach rules by field name
+// instead; structValidator checks them the same way.
+package validation
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.thethings.network/lorawan-stack/pkg/errors"
+)
+
+// Tag is the struct tag name that this package reads rules from.
+const Tag = "validate"
+
+// FieldViolation describes why a single field failed validation.
+//
+// FieldViolation mirrors the shape of a gRPC BadRequest_FieldViolation so
+// that callers can surface it directly in a gRPC error's details.
+type FieldViolation struct {
+	// Field is the dot-separated path of the offending field, e.g. "Password".
+	Field string
+
+	// Description explains why the field is invalid.
+	Description string
+}
+
+// Error aggregates the FieldViolations found by a single Validate call.
+type Error struct {
+	Violations []FieldViolation
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	descs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		descs[i] = v.Field + ": " + v.Description
+	}
+	return "validation failed: " + strings.Join(descs, "; ")
+}
+
+// Validator validates arbitrary values.
+type Validator interface {
+	// Validate returns an *Error if v does not satisfy its validate tags,
+	// and nil otherwise.
+	Validate(v interface{}) error
+}
+
+// Rule checks a single value against an argument (the text following "=" in
+// the struct tag, or the empty string if there is none) and returns a
+// human-readable description of the problem, or the empty string if the
+// value is valid.
+type Rule func(rv reflect.Value, arg string) (description string)
+
+var rules = map[string]Rule{
+	"required": requiredRule,
+	"min":      minRule,
+	"email":    emailRule,
+	"password": passwordRule,
+	"deveui":   devEUIRule,
+	"joineui":  joinEUIRule,
+	"freqplan": frequencyPlanRule,
+	"rights":   rightsRule,
+}
+
+// RegisterRule makes a named Rule available to every Validator returned by New.
+// RegisterRule is typically called from an init func by packages that add
+// domain-specific rules (e.g. LoRaWAN field formats).
+func RegisterRule(name string, rule Rule) {
+	rules[name] = rule
+}
+
+// ExternalFieldRule names a registered Rule (and its optional argument) to
+// apply to one field of a type, equivalent to a single comma-separated entry
+// of a `validate:"..."` tag.
+type ExternalFieldRule struct {
+	// Field is the name of the struct field this rule applies to.
+	Field string
+
+	// Rule is the name of a registered Rule, e.g. "email" or "min".
+	Rule string
+
+	// Arg is the rule's argument, equivalent to the text after "=" in a
+	// `validate:"..."` tag entry.
+	Arg string
+}
+
+var externalRules = map[reflect.Type][]ExternalFieldRule{}
+
+// RegisterExternalFieldRules attaches fieldRules to typ, for types such as
+// ttnpb.User and ttnpb.APIKey whose source lives outside this tree and so
+// cannot be given `validate:"..."` struct tags directly. structValidator
+// checks them the same way it checks tags, just keyed by typ instead of read
+// off the field itself.
+func RegisterExternalFieldRules(typ reflect.Type, fieldRules ...ExternalFieldRule) {
+	externalRules[typ] = append(externalRules[typ], fieldRules...)
+}
+
+// structValidator is the Validator returned by New.
+type structValidator struct{}
+
+// New returns the default, struct-tag driven Validator.
+func New() Validator {
+	return structValidator{}
+}
+
+// Validate implements Validator.
+func (structValidator) Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.Errorf("validation: %T is not a struct", v)
+	}
+
+	var violations []FieldViolation
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup(Tag)
+		if !ok || tag == "-" {
+			continue
+		}
+
+		fv := rv.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			name, arg := rule, ""
+			if idx := strings.IndexByte(rule, '='); idx >= 0 {
+				name, arg = rule[:idx], rule[idx+1:]
+			}
+
+			check, ok := rules[name]
+			if !ok {
+				return errors.Errorf("validation: unknown rule %q on field %q", name, field.Name)
+			}
+			if desc := check(fv, arg); desc != "" {
+				violations = append(violations, FieldViolation{Field: field.Name, Description: desc})
+			}
+		}
+	}
+
+	for _, fr := range externalRules[rt] {
+		fv := rv.FieldByName(fr.Field)
+		if !fv.IsValid() {
+			return errors.Errorf("validation: external rule for field %q, but %s has no such field", fr.Field, rt)
+		}
+
+		check, ok := rules[fr.Rule]
+		if !ok {
+			return errors.Errorf("validation: unknown rule %q on field %q", fr.Rule, fr.Field)
+		}
+		if desc := check(fv, fr.Arg); desc != "" {
+			violations = append(violations, FieldViolation{Field: fr.Field, Description: desc})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+func isZero(rv reflect.Value) bool {
+	return rv.IsValid() && rv.Interface() == reflect.Zero(rv.Type()).Interface()
+}
+
+func requiredRule(rv reflect.Value, _ string) string {
+	if isZero(rv) {
+		return "is required"
+	}
+	return ""
+}
+
+func minRule(rv reflect.Value, arg string) string {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return ""
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		if len(rv.String()) < n {
+			return "must be at least " + arg + " characters long"
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Len() < n {
+			return "must have at least " + arg + " elements"
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < int64(n) {
+			return "must be at least " + arg
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() < uint64(n) {
+			return "must be at least " + arg
+		}
+	}
+	return ""
+}
+
+func emailRule(rv reflect.Value, _ string) string {
+	if rv.Kind() != reflect.String {
+		return ""
+	}
+	s := rv.String()
+	if s == "" {
+		return ""
+	}
+	at := strings.IndexByte(s, '@')
+	if at <= 0 || at == len(s)-1 || strings.IndexByte(s[at+1:], '.') < 0 {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+// passwordRule requires at least one letter and one digit, on top of any
+// "min" length rule given alongside it.
+func passwordRule(rv reflect.Value, _ string) string {
+	if rv.Kind() != reflect.String {
+		return ""
+	}
+	s := rv.String()
+	if s == "" {
+		return ""
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			hasLetter = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return "must contain at least one letter and one digit"
+	}
+	return ""
+}